@@ -2,46 +2,227 @@ package webhook
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/linkerd/linkerd2/controller/k8s"
 	pkgk8s "github.com/linkerd/linkerd2/pkg/k8s"
 	pkgTls "github.com/linkerd/linkerd2/pkg/tls"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	admissionv1 "k8s.io/api/admission/v1"
 	admissionv1beta1 "k8s.io/api/admission/v1beta1"
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/server/dynamiccertificates"
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/yaml"
 )
 
+// defaultCertValidity is how long a self-managed serving cert is valid
+// for when ServerOptions.CertValidity is unset.
+const defaultCertValidity = 365 * 24 * time.Hour
+
+// defaultCertRenewBeforeFraction is the fraction of CertValidity elapsed at which
+// a self-managed serving cert is rotated, when ServerOptions.CertRenewBefore
+// is unset: renew once the cert is 2/3 of the way through its validity.
+const defaultCertRenewBeforeFraction = 2.0 / 3.0
+
+// certRecheckInterval is how often, in CertProvisioningModeFS, a loaded
+// certificate is re-validated even in the absence of an fsnotify event, so
+// that a silently-expiring cert still gets flagged. Self-managed mode does
+// not run this re-check.
+const certRecheckInterval = 10 * time.Minute
+
+var (
+	admissionRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "linkerd_webhook_admission_requests_total",
+			Help: "A counter for admission requests handled by the webhook server, by path and outcome.",
+		},
+		[]string{"path", "allowed"},
+	)
+	admissionRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "linkerd_webhook_admission_duration_seconds",
+			Help: "A histogram of how long it took to handle an admission request, by path.",
+		},
+		[]string{"path"},
+	)
+)
+
+// registerMetrics registers the webhook admission metrics against
+// registerer, tolerating an already-registered collector so that
+// multiple Servers sharing the same registerer (e.g. a mutating and a
+// validating webhook in one binary) don't fail to start.
+func registerMetrics(registerer prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{admissionRequestsTotal, admissionRequestDuration} {
+		if err := registerer.Register(c); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+				return fmt.Errorf("failed to register webhook metrics: %s", err)
+			}
+		}
+	}
+	return nil
+}
+
 // Handler is the signature for the functions that ultimately deal with
-// the admission request
+// the admission request. It is version-agnostic: the server normalizes
+// both admission.k8s.io/v1 and admission.k8s.io/v1beta1 requests into a
+// v1 AdmissionRequest before dispatching, and re-encodes the v1
+// AdmissionResponse it gets back into whichever version the client sent.
 type Handler func(
 	context.Context,
 	*k8s.API,
-	*admissionv1beta1.AdmissionRequest,
+	*admissionv1.AdmissionRequest,
 	record.EventRecorder,
-) (*admissionv1beta1.AdmissionResponse, error)
+) (*admissionv1.AdmissionResponse, error)
+
+// admissionVersion identifies which AdmissionReview wire format a request
+// arrived in, so the response can be re-encoded the same way.
+type admissionVersion int
+
+const (
+	admissionVersionV1 admissionVersion = iota
+	admissionVersionV1beta1
+)
+
+// CertProvisioningMode selects how a Server obtains its serving certificate.
+type CertProvisioningMode int
+
+const (
+	// CertProvisioningModeFS watches a cert/key pair written to disk by an
+	// external issuer (e.g. linkerd-identity or cert-manager) and reloads
+	// it whenever it changes. This is the original, and still the default,
+	// behavior.
+	CertProvisioningModeFS CertProvisioningMode = iota
+	// CertProvisioningModeSelfManaged has the Server generate its own
+	// self-signed CA and serving cert, persist them into a Secret, patch
+	// the caBundle of the matching webhook configuration, and rotate the
+	// serving cert in-process before it expires.
+	CertProvisioningModeSelfManaged
+)
+
+// WebhookConfigKind identifies which kind of webhook configuration a
+// self-managed Server patches the caBundle of on rotation.
+type WebhookConfigKind int
+
+// The supported WebhookConfigKind values.
+const (
+	MutatingWebhookConfigKind WebhookConfigKind = iota
+	ValidatingWebhookConfigKind
+)
+
+// SecretRef names the Secret a self-managed Server persists its CA and
+// serving cert into.
+type SecretRef struct {
+	Namespace string
+	Name      string
+}
+
+// ServerOptions configures how a Server provisions its serving
+// certificate. The zero value selects CertProvisioningModeFS, matching
+// the server's original, externally-issued-cert behavior.
+type ServerOptions struct {
+	// Mode selects FS-watched or self-managed certificate provisioning.
+	Mode CertProvisioningMode
+	// CertPath is the directory watched for cert/key updates. Only used
+	// in CertProvisioningModeFS.
+	CertPath string
+	// Secret is where the self-managed CA and serving cert are persisted.
+	// Only used in CertProvisioningModeSelfManaged.
+	Secret SecretRef
+	// WebhookConfigName and WebhookConfigKind identify the
+	// Mutating/ValidatingWebhookConfiguration whose caBundle is patched on
+	// every rotation. Only used in CertProvisioningModeSelfManaged.
+	WebhookConfigName string
+	WebhookConfigKind WebhookConfigKind
+	// WebhookServiceName is the DNS name every loaded serving cert is
+	// validated against, in both modes: a self-managed cert's SAN and CN
+	// are generated to match it, and an FS-provisioned cert is rejected if
+	// it doesn't carry a matching SAN.
+	WebhookServiceName string
+	// CertValidity is how long a generated serving cert is valid for.
+	// Defaults to defaultCertValidity if zero.
+	CertValidity time.Duration
+	// CertRenewBefore is how long before expiry a generated serving cert
+	// is rotated. Defaults to 1/3 of CertValidity if zero.
+	CertRenewBefore time.Duration
+	// PodNamespace and PodName identify the controller Pod that cert
+	// validation Events are recorded against.
+	PodNamespace string
+	PodName      string
+	// HTTP2 tunes the server's HTTP/2 support. kube-apiserver's webhook
+	// client prefers HTTP/2; without it, connections silently fall back
+	// to HTTP/1.1 and pay a tail-latency cost under high pod churn.
+	HTTP2 HTTP2Options
+	// Registerer is the Prometheus registry the per-path admission
+	// metrics are registered against. Callers should pass the
+	// controller's shared registry here; defaults to
+	// prometheus.DefaultRegisterer if nil.
+	Registerer prometheus.Registerer
+}
+
+// HTTP2Options tunes the http2.Server backing a webhook Server's HTTP/2
+// support.
+type HTTP2Options struct {
+	// MaxConcurrentStreams bounds how many concurrent HTTP/2 streams a
+	// single connection may have open. Defaults to http2.Server's own
+	// default (250) if zero.
+	MaxConcurrentStreams uint32
+	// IdleTimeout closes an HTTP/2 connection after this long without
+	// activity. Defaults to http2.Server's own default (no timeout) if
+	// zero.
+	IdleTimeout time.Duration
+}
 
 // Server describes the https server implementing the webhook
 type Server struct {
 	*http.Server
 	api       *k8s.API
-	handler   Handler
+	handlers  map[string]Handler
+	component string
 	cert      *tls.Certificate
+	certPEM   []byte
+	keyPEM    []byte
 	certMutex *sync.RWMutex
 	recorder  record.EventRecorder
+	opts      ServerOptions
+
+	listenerMu sync.Mutex
+	listeners  []dynamiccertificates.Listener
 }
 
-// NewServer returns a new instance of Server
+// Server implements the apiserver dynamiccertificates.CertKeyContentProvider
+// and Notifier interfaces, so the same rotating cert material that feeds
+// GetCertificate can be consumed by an aggregated API server or
+// secure-serving stack running in the same process (e.g. policy-controller).
+var (
+	_ dynamiccertificates.CertKeyContentProvider = &Server{}
+	_ dynamiccertificates.Notifier               = &Server{}
+)
+
+// NewServer returns a new instance of Server serving a single Handler at
+// the server's root path. It is a thin wrapper around
+// NewMultiHandlerServer for binaries that only ever register one handler.
 func NewServer(
 	ctx context.Context,
 	api *k8s.API,
@@ -49,18 +230,61 @@ func NewServer(
 	handler Handler,
 	component string,
 ) (*Server, error) {
-	updateEvent := make(chan struct{})
-	errEvent := make(chan error)
-	watcher := pkgTls.NewFsCredsWatcher(certPath, updateEvent, errEvent)
-	go func() {
-		if err := watcher.StartWatching(ctx); err != nil {
-			log.Fatalf("Failed to start creds watcher: %s", err)
-		}
-	}()
+	return NewServerWithOptions(ctx, api, addr, handler, component, ServerOptions{
+		Mode:     CertProvisioningModeFS,
+		CertPath: certPath,
+	})
+}
 
+// NewServerWithOptions returns a new instance of Server serving a single
+// Handler at the server's root path, provisioning its serving certificate
+// according to opts.Mode. It is a thin wrapper around
+// NewMultiHandlerServer for binaries that only ever register one handler.
+func NewServerWithOptions(
+	ctx context.Context,
+	api *k8s.API,
+	addr string,
+	handler Handler,
+	component string,
+	opts ServerOptions,
+) (*Server, error) {
+	return NewMultiHandlerServer(ctx, api, addr, map[string]Handler{"/": handler}, component, opts)
+}
+
+// NewMultiHandlerServer returns a new instance of Server that registers
+// each of handlers behind an http.ServeMux keyed by path, so one webhook
+// binary can serve independent endpoints (e.g. a mutating and a
+// validating webhook) behind a single Server/certificate. Each path's
+// admission requests are observed with its own set of
+// linkerd_webhook_admission_requests_total/
+// linkerd_webhook_admission_duration_seconds Prometheus series.
+func NewMultiHandlerServer(
+	ctx context.Context,
+	api *k8s.API,
+	addr string,
+	handlers map[string]Handler,
+	component string,
+	opts ServerOptions,
+) (*Server, error) {
+	mux := http.NewServeMux()
 	server := &http.Server{
 		Addr:      addr,
-		TLSConfig: &tls.Config{},
+		TLSConfig: &tls.Config{NextProtos: []string{"h2", "http/1.1"}},
+		Handler:   mux,
+	}
+	if err := http2.ConfigureServer(server, &http2.Server{
+		MaxConcurrentStreams: opts.HTTP2.MaxConcurrentStreams,
+		IdleTimeout:          opts.HTTP2.IdleTimeout,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to configure HTTP/2: %s", err)
+	}
+
+	registerer := opts.Registerer
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	if err := registerMetrics(registerer); err != nil {
+		return nil, err
 	}
 
 	eventBroadcaster := record.NewBroadcaster()
@@ -71,17 +295,43 @@ func NewServer(
 	})
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component})
 
-	s := &Server{server, api, handler, nil, &sync.RWMutex{}, recorder}
-	s.Handler = http.HandlerFunc(s.serve)
+	s := &Server{
+		Server:    server,
+		api:       api,
+		handlers:  handlers,
+		component: component,
+		certMutex: &sync.RWMutex{},
+		recorder:  recorder,
+		opts:      opts,
+	}
+	for path, handler := range handlers {
+		mux.HandleFunc(path, s.serve(path, handler))
+	}
 	server.TLSConfig.GetCertificate = s.getCertificate()
 
-	if err := s.updateCert(); err != nil {
-		log.Fatalf("Failed to initialized certificate: %s", err)
-	}
+	switch opts.Mode {
+	case CertProvisioningModeSelfManaged:
+		if err := s.bootstrapSelfManagedCert(ctx); err != nil {
+			log.Fatalf("Failed to bootstrap self-managed certificate: %s", err)
+		}
+		go s.runSelfManagedRotation(ctx)
 
-	go func() {
-		s.run(updateEvent, errEvent)
-	}()
+	default:
+		updateEvent := make(chan struct{})
+		errEvent := make(chan error)
+		watcher := pkgTls.NewFsCredsWatcher(opts.CertPath, updateEvent, errEvent)
+		go func() {
+			if err := watcher.StartWatching(ctx); err != nil {
+				log.Fatalf("Failed to start creds watcher: %s", err)
+			}
+		}()
+
+		if err := s.updateCert(); err != nil {
+			log.Fatalf("Failed to initialized certificate: %s", err)
+		}
+
+		go s.run(updateEvent, errEvent)
+	}
 
 	return s, nil
 }
@@ -101,13 +351,511 @@ func (s *Server) updateCert() error {
 	if err != nil {
 		return err
 	}
-	s.certMutex.Lock()
-	defer s.certMutex.Unlock()
-	s.cert = &cert
+
+	if err := s.swapCert(&cert, []byte(certPEM), []byte(keyPEM)); err != nil {
+		return err
+	}
 	log.Debug("Certificate has been updated")
 	return nil
 }
 
+// swapCert validates newCert against the certificate currently being
+// served before atomically swapping it, and the PEM bytes it was parsed
+// from, in. If validation fails, the previously loaded certificate is
+// kept, the failure is logged with the rejected cert's fingerprint and
+// subject, and an Event is emitted against the controller Pod. On
+// success, any dynamiccertificates.Listener registered via AddListener is
+// notified.
+func (s *Server) swapCert(newCert *tls.Certificate, certPEM, keyPEM []byte) error {
+	s.certMutex.Lock()
+	if err := validateCert(newCert, s.cert, s.opts.WebhookServiceName, s.opts.Mode == CertProvisioningModeSelfManaged); err != nil {
+		s.certMutex.Unlock()
+		log.Errorf("Refusing to load new certificate: %s", err)
+		s.recordCertEvent(v1.EventTypeWarning, "InvalidCertificate", err.Error())
+		return err
+	}
+
+	s.cert = newCert
+	s.certPEM = certPEM
+	s.keyPEM = keyPEM
+	s.certMutex.Unlock()
+
+	s.notifyListeners()
+	return nil
+}
+
+// CurrentCertKeyContent implements dynamiccertificates.CertKeyContentProvider,
+// returning the PEM bytes of the currently-served cert/key pair from
+// memory, without re-encoding s.cert.
+func (s *Server) CurrentCertKeyContent() ([]byte, []byte) {
+	s.certMutex.RLock()
+	defer s.certMutex.RUnlock()
+	return s.certPEM, s.keyPEM
+}
+
+// Name implements dynamiccertificates.CertKeyContentProvider.
+func (s *Server) Name() string {
+	return s.component
+}
+
+// AddListener implements dynamiccertificates.Notifier, registering
+// listener to be notified whenever the serving cert is rotated.
+func (s *Server) AddListener(listener dynamiccertificates.Listener) {
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+// notifyListeners calls Enqueue on every listener registered via
+// AddListener.
+func (s *Server) notifyListeners() {
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+	for _, l := range s.listeners {
+		l.Enqueue()
+	}
+}
+
+// recheckCert re-runs the same validation checks against the
+// currently-served certificate, without replacing it, so that a cert
+// which is silently approaching (or already past) expiry is still
+// flagged even if no new cert ever arrives.
+func (s *Server) recheckCert() {
+	s.certMutex.RLock()
+	cert := s.cert
+	s.certMutex.RUnlock()
+
+	if cert == nil {
+		return
+	}
+	if err := validateCert(cert, nil, s.opts.WebhookServiceName, s.opts.Mode == CertProvisioningModeSelfManaged); err != nil {
+		log.Warnf("Certificate check failed: %s", err)
+		s.recordCertEvent(v1.EventTypeWarning, "CertificateCheckFailed", err.Error())
+	}
+}
+
+// recordCertEvent emits an Event against the controller Pod named by
+// s.opts.PodNamespace/PodName.
+func (s *Server) recordCertEvent(eventType, reason, message string) {
+	s.recorder.Event(&v1.ObjectReference{
+		Kind:      "Pod",
+		Namespace: s.opts.PodNamespace,
+		Name:      s.opts.PodName,
+	}, eventType, reason, message)
+}
+
+// validateCert parses newCert's leaf (populating newCert.Leaf) and checks
+// that it is not already expired and that it extends validity beyond
+// oldCert (when oldCert is given). A SAN matching webhookServiceName is
+// required when webhookServiceName is non-empty, and the clientAuth/
+// serverAuth extended key usages are required when requireClientAuth is
+// set. Both checks are opt-in, rather than unconditional. The SAN check
+// applies in both modes whenever a service name is configured. The
+// extended key usage check is only requested for self-managed certs,
+// which this package generates itself: CertProvisioningModeFS serves certs
+// issued by an external issuer (cert-manager/linkerd-identity) that isn't
+// guaranteed to set them.
+func validateCert(newCert, oldCert *tls.Certificate, webhookServiceName string, requireClientAuth bool) error {
+	if len(newCert.Certificate) == 0 {
+		return fmt.Errorf("certificate has no leaf bytes")
+	}
+	leaf, err := x509.ParseCertificate(newCert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse leaf certificate: %s", err)
+	}
+	newCert.Leaf = leaf
+
+	if time.Now().After(leaf.NotAfter) {
+		return fmt.Errorf("certificate %s expired at %s", fingerprintAndSubject(leaf), leaf.NotAfter)
+	}
+
+	if oldCert != nil && oldCert.Leaf != nil && !leaf.NotAfter.After(oldCert.Leaf.NotAfter) {
+		return fmt.Errorf(
+			"certificate %s (NotAfter %s) does not extend validity beyond the currently loaded certificate (NotAfter %s)",
+			fingerprintAndSubject(leaf), leaf.NotAfter, oldCert.Leaf.NotAfter,
+		)
+	}
+
+	if webhookServiceName != "" {
+		if err := leaf.VerifyHostname(webhookServiceName); err != nil {
+			return fmt.Errorf("certificate %s is not valid for %s: %s", fingerprintAndSubject(leaf), webhookServiceName, err)
+		}
+	}
+
+	if requireClientAuth {
+		var hasClientAuth, hasServerAuth bool
+		for _, eku := range leaf.ExtKeyUsage {
+			switch eku {
+			case x509.ExtKeyUsageClientAuth:
+				hasClientAuth = true
+			case x509.ExtKeyUsageServerAuth:
+				hasServerAuth = true
+			}
+		}
+		if !hasClientAuth || !hasServerAuth {
+			return fmt.Errorf("certificate %s is missing required clientAuth/serverAuth extended key usage", fingerprintAndSubject(leaf))
+		}
+	}
+
+	return nil
+}
+
+// fingerprintAndSubject formats cert's SHA-256 fingerprint and subject for
+// use in log messages and Events.
+func fingerprintAndSubject(cert *x509.Certificate) string {
+	fingerprint := sha256.Sum256(cert.Raw)
+	return fmt.Sprintf("%s (sha256:%x)", cert.Subject, fingerprint)
+}
+
+// certValidity returns opts.CertValidity, or defaultCertValidity if unset.
+func (o ServerOptions) certValidity() time.Duration {
+	if o.CertValidity > 0 {
+		return o.CertValidity
+	}
+	return defaultCertValidity
+}
+
+// certRenewBefore returns opts.CertRenewBefore, or the point 2/3 of the
+// way through the cert's validity if unset.
+func (o ServerOptions) certRenewBefore() time.Duration {
+	if o.CertRenewBefore > 0 {
+		return o.CertRenewBefore
+	}
+	validity := o.certValidity()
+	return validity - time.Duration(float64(validity)*defaultCertRenewBeforeFraction)
+}
+
+// selfManagedCACertKey and selfManagedCAKeyKey name the Secret data
+// entries holding the self-signed CA cert/key used to issue the serving
+// leaf. The CA is generated once at bootstrap and persisted alongside
+// the leaf so it can be reused, unchanged, to reissue the leaf on every
+// rotation: a CA that itself regenerated on every rotation would drop
+// every replica's previous leaf from trust until the webhook
+// configuration's caBundle caught up to that replica's own rotation.
+const (
+	selfManagedCACertKey = "ca.crt"
+	selfManagedCAKeyKey  = "ca.key"
+)
+
+// selfManagedCA holds a self-signed CA's parsed certificate and signing
+// key, alongside their PEM encodings for persistence.
+type selfManagedCA struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// bootstrapSelfManagedCert generates a self-signed CA and uses it to
+// issue a serving leaf, persists both to the configured Secret (or
+// adopts whatever another replica already wrote there), patches the
+// webhook configuration's caBundle with the CA, and loads the leaf for
+// serving.
+func (s *Server) bootstrapSelfManagedCert(ctx context.Context) error {
+	secretsClient := s.api.Client.CoreV1().Secrets(s.opts.Secret.Namespace)
+
+	existing, err := secretsClient.Get(ctx, s.opts.Secret.Name, metav1.GetOptions{})
+	if err == nil {
+		return s.loadSelfManagedCertFromSecret(ctx, existing)
+	}
+	if !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to fetch %s/%s: %s", s.opts.Secret.Namespace, s.opts.Secret.Name, err)
+	}
+
+	ca, err := s.generateSelfManagedCA()
+	if err != nil {
+		return fmt.Errorf("failed to generate self-signed CA: %s", err)
+	}
+	certPEM, keyPEM, err := s.issueSelfManagedLeaf(ca)
+	if err != nil {
+		return fmt.Errorf("failed to issue serving cert: %s", err)
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: s.opts.Secret.Namespace,
+			Name:      s.opts.Secret.Name,
+		},
+		Type: v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			v1.TLSCertKey:        certPEM,
+			v1.TLSPrivateKeyKey:  keyPEM,
+			selfManagedCACertKey: ca.certPEM,
+			selfManagedCAKeyKey:  ca.keyPEM,
+		},
+	}
+
+	created, err := secretsClient.Create(ctx, secret, metav1.CreateOptions{})
+	if err != nil {
+		// Another replica raced us and created the Secret first; adopt
+		// whatever it wrote rather than erroring out.
+		if k8serrors.IsAlreadyExists(err) {
+			existing, getErr := secretsClient.Get(ctx, s.opts.Secret.Name, metav1.GetOptions{})
+			if getErr != nil {
+				return fmt.Errorf("failed to fetch %s/%s after losing create race: %s", s.opts.Secret.Namespace, s.opts.Secret.Name, getErr)
+			}
+			return s.loadSelfManagedCertFromSecret(ctx, existing)
+		}
+		return fmt.Errorf("failed to persist %s/%s: %s", s.opts.Secret.Namespace, s.opts.Secret.Name, err)
+	}
+
+	return s.loadSelfManagedCertFromSecret(ctx, created)
+}
+
+// loadSelfManagedCertFromSecret parses the leaf cert/key in secret, swaps
+// it into the server, and patches the webhook configuration's caBundle
+// to match secret's CA (not the leaf, which changes on every rotation).
+func (s *Server) loadSelfManagedCertFromSecret(ctx context.Context, secret *v1.Secret) error {
+	cert, err := tls.X509KeyPair(secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey])
+	if err != nil {
+		return fmt.Errorf("failed to parse cert/key from %s/%s: %s", secret.Namespace, secret.Name, err)
+	}
+
+	if err := s.swapCert(&cert, secret.Data[v1.TLSCertKey], secret.Data[v1.TLSPrivateKeyKey]); err != nil {
+		return fmt.Errorf("refusing to load certificate from %s/%s: %s", secret.Namespace, secret.Name, err)
+	}
+
+	caPEM := secret.Data[selfManagedCACertKey]
+	if len(caPEM) == 0 {
+		return fmt.Errorf("no CA certificate found in %s/%s", secret.Namespace, secret.Name)
+	}
+	return s.patchWebhookCABundle(ctx, caPEM)
+}
+
+// generateSelfManagedCA creates a new in-memory self-signed CA, valid for
+// s.opts.certValidity(). It is generated once at bootstrap and persisted
+// in the Secret so issueSelfManagedLeaf can reuse it, unchanged, on every
+// later rotation.
+func (s *Server) generateSelfManagedCA() (*selfManagedCA, error) {
+	now := time.Now()
+
+	serial, err := randomSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: s.opts.WebhookServiceName + "-ca"},
+		NotBefore:             now,
+		NotAfter:              now.Add(s.opts.certValidity()),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to self-sign CA: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated CA: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal CA key: %s", err)
+	}
+
+	return &selfManagedCA{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// parseSelfManagedCA parses the CA cert/key a prior bootstrap persisted
+// in secret, so rotation can reissue the leaf from the same CA rather
+// than minting a new one.
+func parseSelfManagedCA(secret *v1.Secret) (*selfManagedCA, error) {
+	certPEM := secret.Data[selfManagedCACertKey]
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("no CA certificate found in %s/%s", secret.Namespace, secret.Name)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %s", err)
+	}
+
+	keyPEM := secret.Data[selfManagedCAKeyKey]
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("no CA key found in %s/%s", secret.Namespace, secret.Name)
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %s", err)
+	}
+
+	return &selfManagedCA{cert: cert, key: key, certPEM: certPEM, keyPEM: keyPEM}, nil
+}
+
+// issueSelfManagedLeaf issues a serving leaf cert for
+// s.opts.WebhookServiceName, signed by ca and valid for
+// s.opts.certValidity(). The leaf (not the CA cert) is what gets served:
+// it carries the clientAuth/serverAuth extended key usages and DNS SAN
+// validateCert requires, which the CA cert's own KeyUsageCertSign would
+// not satisfy. This mirrors the self-signed bootstrap approach
+// controller-runtime's webhook cert generator uses, rather than
+// depending on an external issuer.
+func (s *Server) issueSelfManagedLeaf(ca *selfManagedCA) (certPEM, keyPEM []byte, err error) {
+	now := time.Now()
+
+	serial, err := randomSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serving key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: s.opts.WebhookServiceName},
+		DNSNames:     []string{s.opts.WebhookServiceName},
+		NotBefore:    now,
+		NotAfter:     now.Add(s.opts.certValidity()),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to issue serving cert: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal serving key: %s", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// randomSerialNumber returns a random 128-bit certificate serial number.
+func randomSerialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %s", err)
+	}
+	return serial, nil
+}
+
+// patchWebhookCABundle sets the caBundle of every entry in the webhook
+// configuration named in s.opts.WebhookConfigName to caPEM. It reads the
+// configuration, mutates its in-memory copy and Updates it with the
+// resourceVersion from that read, so the apiserver rejects the write with
+// a conflict (surfaced to the caller as an error) if another replica
+// raced it in between, rather than silently clobbering that replica's
+// update.
+func (s *Server) patchWebhookCABundle(ctx context.Context, caPEM []byte) error {
+	switch s.opts.WebhookConfigKind {
+	case ValidatingWebhookConfigKind:
+		client := s.api.Client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+		cfg, err := client.Get(ctx, s.opts.WebhookConfigName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for i := range cfg.Webhooks {
+			cfg.Webhooks[i].ClientConfig.CABundle = caPEM
+		}
+		_, err = client.Update(ctx, cfg, metav1.UpdateOptions{})
+		return err
+	default:
+		client := s.api.Client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+		cfg, err := client.Get(ctx, s.opts.WebhookConfigName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		for i := range cfg.Webhooks {
+			cfg.Webhooks[i].ClientConfig.CABundle = caPEM
+		}
+		_, err = client.Update(ctx, cfg, metav1.UpdateOptions{})
+		return err
+	}
+}
+
+// rotateSelfManagedCert reissues a fresh serving leaf from the CA
+// persisted in the Secret (the CA itself is not regenerated, so the
+// webhook configuration's caBundle stays valid across the rotation),
+// overwrites the existing Secret with it using a resourceVersion-checked
+// Update (so the apiserver rejects the write, rather than silently
+// clobbering it, if another replica raced it), swaps the new leaf in and
+// re-patches the webhook configuration's caBundle to match.
+func (s *Server) rotateSelfManagedCert(ctx context.Context) error {
+	secretsClient := s.api.Client.CoreV1().Secrets(s.opts.Secret.Namespace)
+
+	existing, err := secretsClient.Get(ctx, s.opts.Secret.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s/%s: %s", s.opts.Secret.Namespace, s.opts.Secret.Name, err)
+	}
+
+	ca, err := parseSelfManagedCA(existing)
+	if err != nil {
+		return fmt.Errorf("failed to load CA from %s/%s: %s", existing.Namespace, existing.Name, err)
+	}
+	certPEM, keyPEM, err := s.issueSelfManagedLeaf(ca)
+	if err != nil {
+		return fmt.Errorf("failed to issue serving cert: %s", err)
+	}
+
+	updated := existing.DeepCopy()
+	if updated.Data == nil {
+		updated.Data = map[string][]byte{}
+	}
+	updated.Data[v1.TLSCertKey] = certPEM
+	updated.Data[v1.TLSPrivateKeyKey] = keyPEM
+
+	persisted, err := secretsClient.Update(ctx, updated, metav1.UpdateOptions{})
+	if err != nil {
+		// Another replica rotated first; adopt whatever it wrote rather
+		// than erroring out and waiting for our own next tick.
+		if k8serrors.IsConflict(err) {
+			winner, getErr := secretsClient.Get(ctx, s.opts.Secret.Name, metav1.GetOptions{})
+			if getErr != nil {
+				return fmt.Errorf("failed to fetch %s/%s after losing rotation race: %s", s.opts.Secret.Namespace, s.opts.Secret.Name, getErr)
+			}
+			return s.loadSelfManagedCertFromSecret(ctx, winner)
+		}
+		return fmt.Errorf("failed to persist rotated cert to %s/%s: %s", s.opts.Secret.Namespace, s.opts.Secret.Name, err)
+	}
+
+	return s.loadSelfManagedCertFromSecret(ctx, persisted)
+}
+
+// runSelfManagedRotation periodically checks whether the self-managed
+// serving cert is within its renewal window and, if so, regenerates,
+// persists and swaps it in.
+func (s *Server) runSelfManagedRotation(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.certMutex.RLock()
+			leaf := s.cert.Leaf
+			s.certMutex.RUnlock()
+
+			if leaf != nil && time.Until(leaf.NotAfter) > s.opts.certRenewBefore() {
+				continue
+			}
+
+			if err := s.rotateSelfManagedCert(ctx); err != nil {
+				log.Warnf("Failed to rotate self-managed certificate: %s", err)
+			} else {
+				log.Infof("Rotated self-managed certificate")
+			}
+		}
+	}
+}
+
 // Start starts the https server
 func (s *Server) Start() {
 	log.Infof("listening at %s", s.Server.Addr)
@@ -128,8 +876,13 @@ func (s *Server) getCertificate() func(*tls.ClientHelloInfo) (*tls.Certificate,
 	}
 }
 
-// run reads from the update and error channels and reloads the certs when necessary
+// run reads from the update and error channels and reloads the certs when
+// necessary, and periodically re-checks the loaded cert even without an
+// fsnotify event.
 func (s *Server) run(updateEvent <-chan struct{}, errEvent <-chan error) {
+	ticker := time.NewTicker(certRecheckInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-updateEvent:
@@ -140,72 +893,88 @@ func (s *Server) run(updateEvent <-chan struct{}, errEvent <-chan error) {
 			}
 		case err := <-errEvent:
 			log.Warnf("Received error from fs watcher: %s", err)
+		case <-ticker.C:
+			s.recheckCert()
 		}
 	}
 }
 
-func (s *Server) serve(res http.ResponseWriter, req *http.Request) {
-	var (
-		data []byte
-		err  error
-	)
-	if req.Body != nil {
-		data, err = ioutil.ReadAll(req.Body)
+// serve returns the http.HandlerFunc registered for path, dispatching
+// admission requests to handler and observing per-path Prometheus
+// metrics around it.
+func (s *Server) serve(path string, handler Handler) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+
+		var (
+			data []byte
+			err  error
+		)
+		if req.Body != nil {
+			data, err = ioutil.ReadAll(req.Body)
+			if err != nil {
+				http.Error(res, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if len(data) == 0 {
+			log.Warn("received empty payload")
+			return
+		}
+
+		responseJSON, allowed, err := s.processReq(req.Context(), handler, data)
+		admissionRequestsTotal.WithLabelValues(path, strconv.FormatBool(allowed)).Inc()
+		admissionRequestDuration.WithLabelValues(path).Observe(time.Since(start).Seconds())
 		if err != nil {
 			http.Error(res, err.Error(), http.StatusInternalServerError)
 			return
 		}
-	}
 
-	if len(data) == 0 {
-		log.Warn("received empty payload")
-		return
-	}
-
-	response := s.processReq(req.Context(), data)
-	responseJSON, err := json.Marshal(response)
-	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	if _, err := res.Write(responseJSON); err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
-		return
+		if _, err := res.Write(responseJSON); err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 }
 
-func (s *Server) processReq(ctx context.Context, data []byte) *admissionv1beta1.AdmissionReview {
-	admissionReview, err := decode(data)
+// processReq decodes an AdmissionReview in whichever of the supported
+// versions the client sent, dispatches it to handler and re-encodes the
+// result in that same version. The returned bool reports whether the
+// admission was allowed, for metrics purposes.
+func (s *Server) processReq(ctx context.Context, handler Handler, data []byte) ([]byte, bool, error) {
+	admissionReview, version, err := decode(data)
 	if err != nil {
 		log.Errorf("failed to decode data. Reason: %s", err)
-		admissionReview.Response = &admissionv1beta1.AdmissionResponse{
-			UID:     admissionReview.Request.UID,
+		admissionReview = &admissionv1.AdmissionReview{Response: &admissionv1.AdmissionResponse{
 			Allowed: false,
 			Result: &metav1.Status{
 				Message: err.Error(),
 			},
-		}
-		return admissionReview
+		}}
+		responseJSON, err := encode(admissionReview, version)
+		return responseJSON, false, err
 	}
 	log.Infof("received admission review request %s", admissionReview.Request.UID)
 	log.Debugf("admission request: %+v", admissionReview.Request)
 
-	admissionResponse, err := s.handler(ctx, s.api, admissionReview.Request, s.recorder)
+	admissionResponse, err := handler(ctx, s.api, admissionReview.Request, s.recorder)
 	if err != nil {
 		log.Error("failed to run webhook handler. Reason: ", err)
-		admissionReview.Response = &admissionv1beta1.AdmissionResponse{
+		admissionReview.Response = &admissionv1.AdmissionResponse{
 			UID:     admissionReview.Request.UID,
 			Allowed: false,
 			Result: &metav1.Status{
 				Message: err.Error(),
 			},
 		}
-		return admissionReview
+		responseJSON, err := encode(admissionReview, version)
+		return responseJSON, false, err
 	}
 	admissionReview.Response = admissionResponse
 
-	return admissionReview
+	responseJSON, err := encode(admissionReview, version)
+	return responseJSON, admissionResponse.Allowed, err
 }
 
 // Shutdown initiates a graceful shutdown of the underlying HTTP server.
@@ -213,8 +982,94 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	return s.Server.Shutdown(ctx)
 }
 
-func decode(data []byte) (*admissionv1beta1.AdmissionReview, error) {
-	var admissionReview admissionv1beta1.AdmissionReview
-	err := yaml.Unmarshal(data, &admissionReview)
-	return &admissionReview, err
+// decode parses an incoming AdmissionReview payload, detecting whether it
+// is the admission.k8s.io/v1 or v1beta1 wire format from its apiVersion,
+// and normalizes the result into a v1 AdmissionReview so the rest of the
+// server only has to deal with one shape.
+func decode(data []byte) (*admissionv1.AdmissionReview, admissionVersion, error) {
+	var typeMeta metav1.TypeMeta
+	if err := yaml.Unmarshal(data, &typeMeta); err != nil {
+		return nil, admissionVersionV1, err
+	}
+
+	if typeMeta.APIVersion == admissionv1beta1.SchemeGroupVersion.String() {
+		var review admissionv1beta1.AdmissionReview
+		if err := yaml.Unmarshal(data, &review); err != nil {
+			return nil, admissionVersionV1beta1, err
+		}
+		return v1beta1ReviewToV1(&review), admissionVersionV1beta1, nil
+	}
+
+	var review admissionv1.AdmissionReview
+	if err := yaml.Unmarshal(data, &review); err != nil {
+		return nil, admissionVersionV1, err
+	}
+	return &review, admissionVersionV1, nil
+}
+
+// encode re-serializes a v1 AdmissionReview back into the wire version the
+// client originally sent.
+func encode(review *admissionv1.AdmissionReview, version admissionVersion) ([]byte, error) {
+	if version == admissionVersionV1beta1 {
+		return json.Marshal(v1ReviewToV1beta1(review))
+	}
+	review.TypeMeta = metav1.TypeMeta{
+		APIVersion: admissionv1.SchemeGroupVersion.String(),
+		Kind:       "AdmissionReview",
+	}
+	return json.Marshal(review)
+}
+
+// v1beta1ReviewToV1 converts an admission.k8s.io/v1beta1 AdmissionReview
+// into the v1 shape. The two versions are field-for-field compatible
+// apart from their package, so this is a straight copy.
+func v1beta1ReviewToV1(review *admissionv1beta1.AdmissionReview) *admissionv1.AdmissionReview {
+	out := &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionv1.SchemeGroupVersion.String(),
+			Kind:       "AdmissionReview",
+		},
+	}
+	if req := review.Request; req != nil {
+		out.Request = &admissionv1.AdmissionRequest{
+			UID:                req.UID,
+			Kind:               req.Kind,
+			Resource:           req.Resource,
+			SubResource:        req.SubResource,
+			RequestKind:        req.RequestKind,
+			RequestResource:    req.RequestResource,
+			RequestSubResource: req.RequestSubResource,
+			Name:               req.Name,
+			Namespace:          req.Namespace,
+			Operation:          admissionv1.Operation(req.Operation),
+			UserInfo:           req.UserInfo,
+			Object:             req.Object,
+			OldObject:          req.OldObject,
+			DryRun:             req.DryRun,
+			Options:            req.Options,
+		}
+	}
+	return out
+}
+
+// v1ReviewToV1beta1 converts a v1 AdmissionReview back into the
+// admission.k8s.io/v1beta1 shape expected by older API servers.
+func v1ReviewToV1beta1(review *admissionv1.AdmissionReview) *admissionv1beta1.AdmissionReview {
+	out := &admissionv1beta1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionv1beta1.SchemeGroupVersion.String(),
+			Kind:       "AdmissionReview",
+		},
+	}
+	if resp := review.Response; resp != nil {
+		out.Response = &admissionv1beta1.AdmissionResponse{
+			UID:              resp.UID,
+			Allowed:          resp.Allowed,
+			Result:           resp.Result,
+			Patch:            resp.Patch,
+			PatchType:        (*admissionv1beta1.PatchType)(resp.PatchType),
+			AuditAnnotations: resp.AuditAnnotations,
+		}
+	}
+	return out
 }
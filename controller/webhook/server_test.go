@@ -0,0 +1,277 @@
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/linkerd/linkerd2/controller/k8s"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http2"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/yaml"
+)
+
+func TestDecodeEncodeRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		apiVersion string
+		version    admissionVersion
+	}{
+		{"v1", admissionv1.SchemeGroupVersion.String(), admissionVersionV1},
+		{"v1beta1", admissionv1beta1.SchemeGroupVersion.String(), admissionVersionV1beta1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			uid := types.UID("123e4567-e89b-12d3-a456-426614174000")
+			raw := []byte(fmt.Sprintf(
+				`{"apiVersion":%q,"kind":"AdmissionReview","request":{"uid":%q,"operation":"CREATE"}}`,
+				tc.apiVersion, uid,
+			))
+
+			review, version, err := decode(raw)
+			if err != nil {
+				t.Fatalf("decode: %s", err)
+			}
+			if version != tc.version {
+				t.Fatalf("expected version %v, got %v", tc.version, version)
+			}
+			if review.Request == nil || review.Request.UID != uid {
+				t.Fatalf("expected request UID %s, got %+v", uid, review.Request)
+			}
+
+			review.Response = &admissionv1.AdmissionResponse{UID: uid, Allowed: true}
+			out, err := encode(review, version)
+			if err != nil {
+				t.Fatalf("encode: %s", err)
+			}
+
+			var typeMeta metav1.TypeMeta
+			if err := yaml.Unmarshal(out, &typeMeta); err != nil {
+				t.Fatalf("unmarshal re-encoded review: %s", err)
+			}
+			if typeMeta.APIVersion != tc.apiVersion {
+				t.Errorf("expected re-encoded apiVersion %s, got %s", tc.apiVersion, typeMeta.APIVersion)
+			}
+
+			if tc.version == admissionVersionV1beta1 {
+				var v1beta1Review admissionv1beta1.AdmissionReview
+				if err := yaml.Unmarshal(out, &v1beta1Review); err != nil {
+					t.Fatalf("unmarshal v1beta1 response: %s", err)
+				}
+				if v1beta1Review.Response == nil || v1beta1Review.Response.UID != uid || !v1beta1Review.Response.Allowed {
+					t.Errorf("unexpected v1beta1 response: %+v", v1beta1Review.Response)
+				}
+			} else {
+				var v1Review admissionv1.AdmissionReview
+				if err := yaml.Unmarshal(out, &v1Review); err != nil {
+					t.Fatalf("unmarshal v1 response: %s", err)
+				}
+				if v1Review.Response == nil || v1Review.Response.UID != uid || !v1Review.Response.Allowed {
+					t.Errorf("unexpected v1 response: %+v", v1Review.Response)
+				}
+			}
+		})
+	}
+}
+
+// TestHTTP2 builds a Server via NewMultiHandlerServer, against a fake
+// clientset seeded with the webhook configuration a self-managed bootstrap
+// patches (the bootstrap itself runs inside NewMultiHandlerServer), and
+// confirms a client that only speaks HTTP/2 can complete a request
+// against the listener it ends up serving on.
+func TestHTTP2(t *testing.T) {
+	opts := ServerOptions{
+		Mode:               CertProvisioningModeSelfManaged,
+		WebhookServiceName: "test-webhook.linkerd.svc",
+		Secret:             SecretRef{Namespace: "linkerd", Name: "webhook-cert"},
+		WebhookConfigName:  "test-webhook-config",
+		CertValidity:       time.Hour,
+		Registerer:         prometheus.NewRegistry(),
+	}
+	webhookConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: opts.WebhookConfigName},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{Name: "test-webhook.linkerd.io"},
+		},
+	}
+	client := fake.NewSimpleClientset(webhookConfig)
+
+	handler := func(_ context.Context, _ *k8s.API, _ *admissionv1.AdmissionRequest, _ record.EventRecorder) (*admissionv1.AdmissionResponse, error) {
+		return &admissionv1.AdmissionResponse{Allowed: true}, nil
+	}
+
+	s, err := NewMultiHandlerServer(context.Background(), &k8s.API{Client: client}, "127.0.0.1:0", map[string]Handler{"/": handler}, "test-webhook", opts)
+	if err != nil {
+		t.Fatalf("NewMultiHandlerServer: %s", err)
+	}
+	defer s.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	go s.ServeTLS(ln, "", "")
+
+	httpClient := &http.Client{
+		Transport: &http2.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	resp, err := httpClient.Get(fmt.Sprintf("https://%s", ln.Addr()))
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Proto != "HTTP/2.0" {
+		t.Errorf("expected HTTP/2.0, got %s", resp.Proto)
+	}
+}
+
+// TestGenerateSelfManagedCertPassesValidation confirms the leaf issued by
+// issueSelfManagedLeaf satisfies validateCert, so CertProvisioningModeSelfManaged
+// never crash-loops at bootstrap on its own output.
+func TestGenerateSelfManagedCertPassesValidation(t *testing.T) {
+	s := &Server{opts: ServerOptions{
+		WebhookServiceName: "linkerd-proxy-injector.linkerd.svc",
+		CertValidity:       24 * time.Hour,
+	}}
+
+	ca, err := s.generateSelfManagedCA()
+	if err != nil {
+		t.Fatalf("generateSelfManagedCA: %s", err)
+	}
+	certPEM, keyPEM, err := s.issueSelfManagedLeaf(ca)
+	if err != nil {
+		t.Fatalf("issueSelfManagedLeaf: %s", err)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("X509KeyPair: %s", err)
+	}
+
+	if err := validateCert(&cert, nil, s.opts.WebhookServiceName, true); err != nil {
+		t.Fatalf("generated self-managed cert failed validation: %s", err)
+	}
+}
+
+// TestRotateSelfManagedCert seeds a fake clientset with the Secret and
+// webhook configuration a prior bootstrap would have created, then drives
+// rotateSelfManagedCert (the function runSelfManagedRotation calls once a
+// cert enters its renewal window) against them. It confirms rotation
+// actually reissues the leaf rather than reloading the Secret it already
+// has: the Secret's leaf and the served cert must change, and the new
+// leaf must extend the old one's validity. It also confirms the CA
+// persisted in the Secret, and therefore the webhook configuration's
+// caBundle, stays the same across the rotation, so replicas that haven't
+// rotated yet keep trusting it.
+func TestRotateSelfManagedCert(t *testing.T) {
+	opts := ServerOptions{
+		WebhookServiceName: "linkerd-proxy-injector.linkerd.svc",
+		Secret:             SecretRef{Namespace: "linkerd", Name: "webhook-cert"},
+		WebhookConfigName:  "linkerd-proxy-injector-webhook-config",
+		WebhookConfigKind:  ValidatingWebhookConfigKind,
+		CertValidity:       24 * time.Hour,
+		CertRenewBefore:    23 * time.Hour,
+	}
+
+	bootstrap := &Server{opts: opts}
+	ca, err := bootstrap.generateSelfManagedCA()
+	if err != nil {
+		t.Fatalf("generateSelfManagedCA: %s", err)
+	}
+	// The original leaf is issued with a shorter validity than rotation
+	// uses, so it already sits inside the CertRenewBefore window and its
+	// NotAfter falls strictly before the rotated leaf's. x509 encodes
+	// NotAfter to the second, so two leaves issued back to back with the
+	// same validity would share a NotAfter and validateCert would refuse
+	// the rotated one.
+	bootstrap.opts.CertValidity = time.Hour
+	certPEM, keyPEM, err := bootstrap.issueSelfManagedLeaf(ca)
+	if err != nil {
+		t.Fatalf("issueSelfManagedLeaf: %s", err)
+	}
+	oldCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("X509KeyPair: %s", err)
+	}
+	oldLeaf, err := x509.ParseCertificate(oldCert.Certificate[0])
+	if err != nil {
+		t.Fatalf("ParseCertificate: %s", err)
+	}
+	oldCert.Leaf = oldLeaf
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: opts.Secret.Namespace,
+			Name:      opts.Secret.Name,
+		},
+		Type: v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			v1.TLSCertKey:        certPEM,
+			v1.TLSPrivateKeyKey:  keyPEM,
+			selfManagedCACertKey: ca.certPEM,
+			selfManagedCAKeyKey:  ca.keyPEM,
+		},
+	}
+	webhookConfig := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: opts.WebhookConfigName},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{Name: "linkerd-proxy-injector.linkerd.io", ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: ca.certPEM}},
+		},
+	}
+
+	client := fake.NewSimpleClientset(secret, webhookConfig)
+	s := &Server{
+		api:       &k8s.API{Client: client},
+		opts:      opts,
+		cert:      &oldCert,
+		certMutex: &sync.RWMutex{},
+	}
+
+	if err := s.rotateSelfManagedCert(context.Background()); err != nil {
+		t.Fatalf("rotateSelfManagedCert: %s", err)
+	}
+
+	rotatedSecret, err := client.CoreV1().Secrets(opts.Secret.Namespace).Get(context.Background(), opts.Secret.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get rotated secret: %s", err)
+	}
+	if string(rotatedSecret.Data[v1.TLSCertKey]) == string(certPEM) {
+		t.Errorf("Secret still holds the original leaf PEM; rotation did not reissue it")
+	}
+	if string(rotatedSecret.Data[selfManagedCACertKey]) != string(ca.certPEM) {
+		t.Errorf("Secret's CA changed across rotation; a stable CA was expected")
+	}
+
+	if string(s.certPEM) == string(certPEM) {
+		t.Errorf("server is still serving the original leaf; rotation did not swap it in")
+	}
+	if !s.cert.Leaf.NotAfter.After(oldLeaf.NotAfter) {
+		t.Errorf("rotated cert NotAfter %s does not extend original NotAfter %s", s.cert.Leaf.NotAfter, oldLeaf.NotAfter)
+	}
+
+	rotatedConfig, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.Background(), opts.WebhookConfigName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get rotated webhook config: %s", err)
+	}
+	for _, wh := range rotatedConfig.Webhooks {
+		if string(wh.ClientConfig.CABundle) != string(ca.certPEM) {
+			t.Errorf("webhook caBundle changed across rotation; expected it to stay pinned to the stable CA")
+		}
+	}
+}